@@ -2,11 +2,14 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,38 +18,198 @@ var ConnectTimeout time.Duration = time.Millisecond * 300
 var ReadTimeout time.Duration = time.Millisecond * 2000
 var WriteTimeout time.Duration = time.Millisecond * 2000
 
+// DefaultIdleTimeout and DefaultPoolTimeout seed Client.IdleTimeout and
+// Client.PoolTimeout when a Client leaves them unset.
+var DefaultIdleTimeout = 5 * time.Minute
+var DefaultPoolTimeout = time.Millisecond * 500
+
+// DialCooldown is how long a Client (or, in a Cluster, one node) is
+// skipped after a dial or request I/O failure.
+var DialCooldown = time.Second * 10
+
 type Client struct {
-	Addr     string
-	nextDial time.Time
-	conns    chan net.Conn
+	Addr string
+
+	nextDialMu sync.Mutex
+	nextDial   time.Time
+
+	// TLSConfig, when non-nil, makes the Client dial with TLS instead of a
+	// plain TCP connection. Set Certificates on it to present a client
+	// certificate for servers requiring mutual auth.
+	TLSConfig *tls.Config
+
+	// PoolSize caps the total number of connections (idle + in use); it
+	// defaults to MaxFreeConns. MinIdleConns, IdleTimeout and MaxConnAge
+	// control how aggressively idle connections are retired; a zero
+	// MaxConnAge means connections are never retired for age. PoolTimeout
+	// is how long getConn waits for a free slot before giving up, rather
+	// than dialing unconditionally.
+	PoolSize     int
+	MinIdleConns int
+	IdleTimeout  time.Duration
+	MaxConnAge   time.Duration
+	PoolTimeout  time.Duration
+
+	// KeepAliveInterval, if non-zero, starts a background goroutine that
+	// periodically pings idle pooled connections and evicts any that fail,
+	// so a conn killed by an intermediate NAT/LB is found before a real
+	// request hits it and burns its timeout budget discovering the dead
+	// conn itself. KeepAliveTimeout bounds each ping (default ReadTimeout).
+	// PingFunc defaults to sending a "version" command.
+	KeepAliveInterval time.Duration
+	KeepAliveTimeout  time.Duration
+	PingFunc          func(net.Conn) error
+
+	poolMu        sync.RWMutex
+	pool          *connPool
+	poolOnce      sync.Once
+	keepAliveOnce sync.Once
+	keepAliveStop chan struct{}
+	keepAliveWG   sync.WaitGroup
+	closeOnce     sync.Once
 }
 
 func NewClient(addr string) *Client {
-	host := &Client{Addr: addr}
-	host.conns = make(chan net.Conn, MaxFreeConns)
-	return host
+	return &Client{Addr: addr}
 }
 
 // Given a string of the form "host", "host:port", or "[ipv6::address]:port",
 // return true if the string includes a port.
 func hasPort(s string) bool { return strings.LastIndex(s, ":") > strings.LastIndex(s, "]") }
 
+// Close is safe to call more than once and safe to call concurrently with
+// in-flight requests; only the first call tears anything down.
 func (host *Client) Close() {
-	if host.conns == nil {
+	host.closeOnce.Do(func() {
+		host.initPool()
+		if host.keepAliveStop != nil {
+			close(host.keepAliveStop)
+			host.keepAliveWG.Wait()
+		}
+		pool := host.swapPool(nil)
+		if pool != nil {
+			pool.Close()
+		}
+	})
+}
+
+// getPool and swapPool guard host.pool so a concurrent Close (which clears
+// it) can never race a getConn/keepAlive read of it.
+func (host *Client) getPool() *connPool {
+	host.poolMu.RLock()
+	defer host.poolMu.RUnlock()
+	return host.pool
+}
+
+func (host *Client) swapPool(p *connPool) *connPool {
+	host.poolMu.Lock()
+	defer host.poolMu.Unlock()
+	old := host.pool
+	host.pool = p
+	return old
+}
+
+// initPool lazily builds the connection pool from the Pool* fields, so
+// callers can set them on the Client returned by NewClient before issuing
+// the first request.
+func (host *Client) initPool() {
+	host.poolOnce.Do(func() {
+		size := host.PoolSize
+		if size <= 0 {
+			size = MaxFreeConns
+		}
+		poolTimeout := host.PoolTimeout
+		if poolTimeout <= 0 {
+			poolTimeout = DefaultPoolTimeout
+		}
+		idleTimeout := host.IdleTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = DefaultIdleTimeout
+		}
+		host.swapPool(newConnPool(host.createConn, size, host.MinIdleConns, idleTimeout, host.MaxConnAge, poolTimeout))
+	})
+	host.startKeepAlive()
+}
+
+// startKeepAlive launches the idle-connection health-check loop if
+// KeepAliveInterval is set. It is a no-op on repeated calls.
+func (host *Client) startKeepAlive() {
+	if host.KeepAliveInterval <= 0 {
 		return
 	}
-	ch := host.conns
-	host.conns = nil
-	close(ch)
+	host.keepAliveOnce.Do(func() {
+		pool := host.getPool()
+		if pool == nil {
+			return
+		}
+		host.keepAliveStop = make(chan struct{})
+		ping := host.PingFunc
+		if ping == nil {
+			ping = host.defaultPing
+		}
+		timeout := host.KeepAliveTimeout
+		if timeout <= 0 {
+			timeout = ReadTimeout
+		}
+		host.keepAliveWG.Add(1)
+		go func() {
+			// pool is captured once here rather than read from host.pool
+			// on every tick, so a concurrent Close (which clears
+			// host.pool) can never race this loop.
+			defer host.keepAliveWG.Done()
+			ticker := time.NewTicker(host.KeepAliveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					pool.CheckIdle(ping, timeout)
+				case <-host.keepAliveStop:
+					return
+				}
+			}
+		}()
+	})
+}
 
-	for c, closed := <-ch; closed; {
-		c.Close()
+// defaultPing sends a lightweight "version" command and waits for a reply,
+// used to detect a pooled connection that was silently killed while idle.
+func (host *Client) defaultPing(conn net.Conn) error {
+	req := &Request{Cmd: "version"}
+	if err := req.Write(conn); err != nil {
+		return err
 	}
+	resp := new(Response)
+	return resp.Read(bufio.NewReader(conn))
+}
+
+// PoolStats returns a snapshot of the connection pool counters.
+func (host *Client) PoolStats() *PoolStats {
+	host.initPool()
+	pool := host.getPool()
+	if pool == nil {
+		return &PoolStats{}
+	}
+	return pool.Stats()
+}
+
+// inCooldown reports whether the Client is still serving a dial or request
+// failure cooldown set by setCooldown.
+func (host *Client) inCooldown() bool {
+	host.nextDialMu.Lock()
+	defer host.nextDialMu.Unlock()
+	return host.nextDial.After(time.Now())
+}
+
+// setCooldown marks the Client as down for DialCooldown, so a Cluster
+// selecting among nodes skips it until the cooldown expires.
+func (host *Client) setCooldown() {
+	host.nextDialMu.Lock()
+	host.nextDial = time.Now().Add(DialCooldown)
+	host.nextDialMu.Unlock()
 }
 
 func (host *Client) createConn() (net.Conn, error) {
-	now := time.Now()
-	if host.nextDial.After(now) {
+	if host.inCooldown() {
 		return nil, errors.New("wait for retry")
 	}
 
@@ -54,95 +217,160 @@ func (host *Client) createConn() (net.Conn, error) {
 	if !hasPort(addr) {
 		addr = addr + ":11211"
 	}
-	conn, err := net.DialTimeout("tcp", addr, ConnectTimeout)
+
+	var conn net.Conn
+	var err error
+	if host.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: ConnectTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, host.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, ConnectTimeout)
+	}
 	if err != nil {
-		host.nextDial = now.Add(time.Second * 10)
+		host.setCooldown()
 		return nil, err
 	}
 	return conn, nil
 }
 
-func (host *Client) getConn() (c net.Conn, err error) {
-	if host.conns == nil {
-		return nil, errors.New("host closed")
+// getConn also returns the connPool the conn was obtained from, so callers
+// keep operating on that pool even if a concurrent Close clears host.pool.
+func (host *Client) getConn(ctx context.Context) (*pooledConn, *connPool, error) {
+	host.initPool()
+	pool := host.getPool()
+	if pool == nil {
+		return nil, nil, errors.New("host closed")
 	}
-	select {
-	case c = <-host.conns:
-	default:
-		c, err = host.createConn()
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
-	return
+	return conn, pool, nil
 }
 
-func (host *Client) releaseConn(conn net.Conn) {
-	if host.conns == nil {
-		conn.Close()
-		return
+// executeCtx runs req over a pooled connection, honoring ctx for
+// cancellation. Unlike a bare timeout, cancelling ctx closes the connection
+// immediately instead of leaving a goroutine racing req.Write/resp.Read
+// against the caller: a closed conn can never be handed back to the pool
+// with a response still pending on the wire.
+func (host *Client) executeCtx(ctx context.Context, req *Request) (resp *Response, err error) {
+	conn, pool, err := host.getConn(ctx)
+	if err != nil {
+		return nil, err
 	}
-	select {
-	case host.conns <- conn:
-	default:
-		conn.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
 	}
-}
 
-func (host *Client) execute(req *Request) (resp *Response, err error) {
-	var conn net.Conn
-	conn, err = host.getConn()
-	if err != nil {
-		return
+	stop := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	// stopWatcher must run, and be waited on, before conn is handed back to
+	// pool via Put/Remove: the watcher closes conn on ctx cancellation, and
+	// a deferred stop running only after Put would let it race a racing
+	// caller that already picked conn back up from the idle list. It is
+	// also deferred, guarded by the Once, so a panic out of req.Write/
+	// resp.Read/req.Check still stops the watcher instead of leaking it.
+	var stopOnce sync.Once
+	stopWatcher := func() {
+		stopOnce.Do(func() {
+			close(stop)
+			<-watchDone
+		})
 	}
+	defer stopWatcher()
 
 	err = req.Write(conn)
 	if err != nil {
+		stopWatcher()
 		log.Print("write request failed:", err)
-		conn.Close()
-		return
+		pool.Remove(conn)
+		if ctx.Err() == nil {
+			// a genuine I/O failure, not the watcher closing conn because
+			// ctx was cancelled/timed out
+			host.setCooldown()
+		}
+		return nil, ctxErr(ctx, err)
 	}
 
 	resp = new(Response)
 	if req.NoReply {
-		host.releaseConn(conn)
+		stopWatcher()
+		pool.Put(conn, true)
 		resp.status = "STORED"
-		return
+		return resp, nil
 	}
 
 	reader := bufio.NewReader(conn)
 	err = resp.Read(reader)
 	if err != nil {
+		stopWatcher()
 		log.Print("read response failed:", err)
-		conn.Close()
-		return
+		pool.Remove(conn)
+		if ctx.Err() == nil {
+			host.setCooldown()
+		}
+		return nil, ctxErr(ctx, err)
 	}
 
 	if err := req.Check(resp); err != nil {
+		stopWatcher()
 		log.Print("unexpected response", req, resp, err)
-		conn.Close()
+		pool.Remove(conn)
 		return nil, err
 	}
 
-	host.releaseConn(conn)
-	return
+	stopWatcher()
+	pool.Put(conn, true)
+	return resp, nil
 }
 
-func (host *Client) executeWithTimeout(req *Request, timeout time.Duration) (resp *Response, err error) {
-	done := make(chan bool, 1)
-	go func() {
-		resp, err = host.execute(req)
-		done <- true
-	}()
+// ctxErr prefers ctx.Err() over err when ctx was the reason the underlying
+// I/O failed, so callers can tell a cancellation/deadline apart from a real
+// connection error.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (host *Client) execute(req *Request) (resp *Response, err error) {
+	return host.executeCtx(context.Background(), req)
+}
 
-	select {
-	case <-done:
-	case <-time.After(timeout):
+func (host *Client) executeWithTimeout(req *Request, timeout time.Duration) (resp *Response, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err = host.executeCtx(ctx, req)
+	if err == context.DeadlineExceeded {
 		err = fmt.Errorf("request %v timeout", req)
 	}
 	return
 }
 
 func (host *Client) Get(key string) (*Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ReadTimeout)
+	defer cancel()
+	item, err := host.GetContext(ctx, key)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("get %q timeout", key)
+	}
+	return item, err
+}
+
+// GetContext is like Get but aborts the request when ctx is done.
+func (host *Client) GetContext(ctx context.Context, key string) (*Item, error) {
 	req := &Request{Cmd: "get", Key: key}
-	resp, err := host.executeWithTimeout(req, ReadTimeout)
+	resp, err := host.executeCtx(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -156,18 +384,40 @@ func (host *Client) store(cmd string, key string, item *Item, noreply bool) (boo
 	return err == nil && resp.status == "STORED", err
 }
 
+func (host *Client) storeContext(ctx context.Context, cmd string, key string, item *Item, noreply bool) (bool, error) {
+	req := &Request{Cmd: cmd, Key: key, Item: item, NoReply: noreply}
+	resp, err := host.executeCtx(ctx, req)
+	return err == nil && resp.status == "STORED", err
+}
+
 func (host *Client) Set(key string, value []byte) (bool, error) {
 	return host.store("set", key, &Item{Body: value}, false)
 }
 
+// SetContext is like Set but aborts the request when ctx is done.
+func (host *Client) SetContext(ctx context.Context, key string, value []byte) (bool, error) {
+	return host.storeContext(ctx, "set", key, &Item{Body: value}, false)
+}
+
 func (host *Client) FlushAll() {
 	req := &Request{Cmd: "flush_all"}
 	host.execute(req)
 }
 
 func (host *Client) Delete(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ReadTimeout)
+	defer cancel()
+	ok, err := host.DeleteContext(ctx, key)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("delete %q timeout", key)
+	}
+	return ok, err
+}
+
+// DeleteContext is like Delete but aborts the request when ctx is done.
+func (host *Client) DeleteContext(ctx context.Context, key string) (bool, error) {
 	req := &Request{Cmd: "delete", Key: key}
-	resp, err := host.execute(req)
+	resp, err := host.executeCtx(ctx, req)
 	return err == nil && resp.status == "DELETED", err
 }
 
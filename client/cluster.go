@@ -0,0 +1,172 @@
+package client
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// node wraps a single backend Client with the bookkeeping a Cluster needs to
+// treat it as a (possibly temporarily) unavailable replica.
+type node struct {
+	name   string
+	client *Client
+}
+
+// Cluster fans keyed operations across a set of memcache nodes using
+// Rendezvous (Highest-Random-Weight) hashing instead of classic modulo or
+// consistent-ring hashing. The main benefit over a ring is that adding or
+// removing a node only reshuffles the keys that belong to that node, without
+// needing virtual nodes to smooth the distribution.
+type Cluster struct {
+	mu    sync.RWMutex
+	nodes []*node
+}
+
+// NewCluster builds a Cluster from a set of node name to address mappings.
+// The node name is hashed together with the key, so renaming a node changes
+// its ownership of keys just like adding or removing one.
+func NewCluster(addrs map[string]string) *Cluster {
+	c := &Cluster{}
+	for name, addr := range addrs {
+		c.nodes = append(c.nodes, &node{name: name, client: NewClient(addr)})
+	}
+	return c
+}
+
+// score returns the rendezvous weight of a node for a given key: the 64-bit
+// FNV-1a hash of nodeName+key. The node with the highest score owns the key.
+func score(nodeName, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(nodeName))
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// pickNodes returns the nodes that currently own key, ordered from most to
+// least preferred, skipping any node still inside its dial cooldown window.
+// If every node is cooling down, the full ordered set is returned anyway so
+// the caller can still attempt a request rather than fail outright.
+func (c *Cluster) pickNodes(key string) []*node {
+	c.mu.RLock()
+	candidates := make([]*node, len(c.nodes))
+	copy(candidates, c.nodes)
+	c.mu.RUnlock()
+
+	live := candidates[:0:0]
+	for _, n := range candidates {
+		if !n.client.inCooldown() {
+			live = append(live, n)
+		}
+	}
+	if len(live) == 0 {
+		live = candidates
+	}
+
+	sort64(live, key)
+	return live
+}
+
+// pickNode returns the single best node for key.
+func (c *Cluster) pickNode(key string) *node {
+	nodes := c.pickNodes(key)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// sort64 orders nodes by descending rendezvous score for key, in place.
+func sort64(nodes []*node, key string) {
+	scores := make([]uint64, len(nodes))
+	for i, n := range nodes {
+		scores[i] = score(n.name, key)
+	}
+	for i := 1; i < len(nodes); i++ {
+		for j := i; j > 0 && scores[j] > scores[j-1]; j-- {
+			scores[j], scores[j-1] = scores[j-1], scores[j]
+			nodes[j], nodes[j-1] = nodes[j-1], nodes[j]
+		}
+	}
+}
+
+// Get runs Get against the node selected for key, falling back to the next
+// best node (in rendezvous order) if the current one is down.
+func (c *Cluster) Get(key string) (*Item, error) {
+	var err error
+	for _, n := range c.pickNodes(key) {
+		var item *Item
+		item, err = n.client.Get(key)
+		if err == nil {
+			return item, nil
+		}
+	}
+	return nil, err
+}
+
+// Set runs Set against the node selected for key, with the same failover
+// behavior as Get.
+func (c *Cluster) Set(key string, value []byte) (bool, error) {
+	var err error
+	for _, n := range c.pickNodes(key) {
+		var ok bool
+		ok, err = n.client.Set(key, value)
+		if err == nil {
+			return ok, nil
+		}
+	}
+	return false, err
+}
+
+// MultiGet groups keys by their chosen node and queries the nodes
+// concurrently, one goroutine per node issuing a Get for each of its keys in
+// turn, merging the results into a single map keyed by the original keys.
+// Keys whose node returns an error are simply omitted from the result.
+func (c *Cluster) MultiGet(keys []string) map[string]*Item {
+	byNode := make(map[*node][]string)
+	for _, key := range keys {
+		n := c.pickNode(key)
+		if n == nil {
+			continue
+		}
+		byNode[n] = append(byNode[n], key)
+	}
+
+	results := make(map[string]*Item, len(keys))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for n, nodeKeys := range byNode {
+		n, nodeKeys := n, nodeKeys
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range nodeKeys {
+				item, err := n.client.Get(key)
+				if err != nil || item == nil {
+					continue
+				}
+				mu.Lock()
+				results[key] = item
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Close closes every node's underlying Client.
+func (c *Cluster) Close() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, n := range c.nodes {
+		n.client.Close()
+	}
+}
+
+// String is useful for debugging which nodes a Cluster currently holds.
+func (c *Cluster) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return "cluster(" + strconv.Itoa(len(c.nodes)) + " nodes)"
+}
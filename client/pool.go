@@ -0,0 +1,339 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolTimeout is returned by connPool.Get when no connection becomes
+// available within PoolTimeout.
+var ErrPoolTimeout = errors.New("client: connection pool timeout")
+
+// reapInterval is how often the background reaper sweeps idle connections
+// for IdleTimeout/MaxConnAge expiry.
+var reapInterval = time.Second
+
+// PoolStats exposes connPool counters, mirroring the go-redis pool stats so
+// operators have a familiar shape to graph.
+type PoolStats struct {
+	Hits       uint32 // number of times a pooled conn was reused
+	Misses     uint32 // number of times Get found no idle conn to reuse
+	Timeouts   uint32 // number of times Get gave up waiting for a slot
+	TotalConns uint32 // open connections (idle + in use)
+	IdleConns  uint32 // currently idle connections
+}
+
+// pooledConn wraps a net.Conn with the bookkeeping connPool needs to expire
+// it on age or idle time.
+type pooledConn struct {
+	net.Conn
+	createdAt time.Time
+	usedAt    time.Time
+}
+
+// connPool is a bounded pool of net.Conn modeled on go-redis's
+// internal/pool: it caps the number of connections at PoolSize, retires
+// connections older than MaxConnAge or idle longer than IdleTimeout, and
+// makes Get wait up to PoolTimeout for a slot instead of always dialing (or
+// silently dropping an overflowing conn, as the old fixed-size channel did).
+type connPool struct {
+	dial func() (net.Conn, error)
+
+	poolSize     int
+	minIdleConns int
+	idleTimeout  time.Duration
+	maxConnAge   time.Duration
+	poolTimeout  time.Duration
+
+	queue chan struct{} // one token per open connection slot, bounds PoolSize
+
+	mu                     sync.Mutex
+	idle                   []*pooledConn
+	open                   uint32 // atomic: total open conns (idle + in use)
+	hits, misses, timeouts uint32
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnPool(dial func() (net.Conn, error), poolSize, minIdleConns int, idleTimeout, maxConnAge, poolTimeout time.Duration) *connPool {
+	p := &connPool{
+		dial:         dial,
+		poolSize:     poolSize,
+		minIdleConns: minIdleConns,
+		idleTimeout:  idleTimeout,
+		maxConnAge:   maxConnAge,
+		poolTimeout:  poolTimeout,
+		queue:        make(chan struct{}, poolSize),
+		closed:       make(chan struct{}),
+	}
+	go p.reaper()
+	if minIdleConns > 0 {
+		go p.maintainMinIdle()
+	}
+	return p
+}
+
+// maintainMinIdle tops up the idle list to MinIdleConns by dialing spare
+// connections, bounded by PoolSize. Dial errors are dropped silently: the
+// reaper tick will simply retry on the next pass.
+func (p *connPool) maintainMinIdle() {
+	for {
+		p.mu.Lock()
+		idleLen := len(p.idle)
+		p.mu.Unlock()
+		if idleLen >= p.minIdleConns {
+			return
+		}
+
+		select {
+		case p.queue <- struct{}{}:
+		default:
+			return // pool already at PoolSize; nothing more to add
+		}
+
+		conn, err := p.dial()
+		if err != nil {
+			<-p.queue
+			return
+		}
+		atomic.AddUint32(&p.open, 1)
+		now := time.Now()
+		p.mu.Lock()
+		p.idle = append(p.idle, &pooledConn{Conn: conn, createdAt: now, usedAt: now})
+		p.mu.Unlock()
+	}
+}
+
+// Get returns an idle connection if one is fresh enough, otherwise dials a
+// new one once a slot is available, waiting up to PoolTimeout (or until ctx
+// is done) for one to free up.
+func (p *connPool) Get(ctx context.Context) (*pooledConn, error) {
+	if c := p.popIdle(); c != nil {
+		atomic.AddUint32(&p.hits, 1)
+		return c, nil
+	}
+
+	atomic.AddUint32(&p.misses, 1)
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		timer := time.NewTimer(p.poolTimeout)
+		defer timer.Stop()
+		select {
+		case p.queue <- struct{}{}:
+		case <-timer.C:
+			atomic.AddUint32(&p.timeouts, 1)
+			return nil, ErrPoolTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		<-p.queue // give back the slot we reserved
+		return nil, err
+	}
+	atomic.AddUint32(&p.open, 1)
+	now := time.Now()
+	return &pooledConn{Conn: conn, createdAt: now, usedAt: now}, nil
+}
+
+// Put returns conn to the idle list, unless it is unhealthy or already past
+// MaxConnAge, in which case it is closed and its slot freed. Put stamps
+// usedAt first, so IdleTimeout is enforced later by popIdle and the reaper,
+// not here.
+// The conn's deadline is always cleared first: executeCtx may have armed an
+// absolute SetDeadline for the request that just finished, and a reused
+// conn must not inherit it.
+func (p *connPool) Put(c *pooledConn, healthy bool) {
+	select {
+	case <-p.closed:
+		// Close already ran and drained the idle list it held at the
+		// time; don't let a late Put grow it back indefinitely.
+		p.removeConn(c)
+		return
+	default:
+	}
+
+	c.usedAt = time.Now()
+	c.SetDeadline(time.Time{})
+	if healthy && !p.expired(c) {
+		p.mu.Lock()
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+		return
+	}
+	p.removeConn(c)
+}
+
+// Remove discards conn without returning it to the idle list, e.g. after a
+// write/read error that leaves the connection's framing unreliable.
+func (p *connPool) Remove(c *pooledConn) {
+	p.removeConn(c)
+}
+
+func (p *connPool) removeConn(c *pooledConn) {
+	c.Close()
+	atomic.AddUint32(&p.open, ^uint32(0)) // open--
+	select {
+	case <-p.queue:
+	default:
+	}
+}
+
+func (p *connPool) expired(c *pooledConn) bool {
+	now := time.Now()
+	if p.maxConnAge > 0 && now.Sub(c.createdAt) > p.maxConnAge {
+		return true
+	}
+	if p.idleTimeout > 0 && now.Sub(c.usedAt) > p.idleTimeout {
+		return true
+	}
+	return false
+}
+
+func (p *connPool) popIdle() *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		n := len(p.idle) - 1
+		c := p.idle[n]
+		p.idle = p.idle[:n]
+		if p.expired(c) {
+			p.mu.Unlock()
+			p.removeConn(c)
+			p.mu.Lock()
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+// reaper periodically evicts expired idle connections so a conn that is
+// simply never reused again still gets closed instead of lingering until
+// the next Get happens to pop it.
+func (p *connPool) reaper() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.reapExpired()
+			if p.minIdleConns > 0 {
+				p.maintainMinIdle()
+			}
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *connPool) reapExpired() {
+	p.mu.Lock()
+	fresh := p.idle[:0]
+	var stale []*pooledConn
+	for _, c := range p.idle {
+		if p.expired(c) {
+			stale = append(stale, c)
+		} else {
+			fresh = append(fresh, c)
+		}
+	}
+	p.idle = fresh
+	p.mu.Unlock()
+
+	for _, c := range stale {
+		p.removeConn(c)
+	}
+}
+
+// Stats returns a snapshot of the pool counters.
+func (p *connPool) Stats() *PoolStats {
+	p.mu.Lock()
+	idleConns := len(p.idle)
+	p.mu.Unlock()
+
+	return &PoolStats{
+		Hits:       atomic.LoadUint32(&p.hits),
+		Misses:     atomic.LoadUint32(&p.misses),
+		Timeouts:   atomic.LoadUint32(&p.timeouts),
+		TotalConns: atomic.LoadUint32(&p.open),
+		IdleConns:  uint32(idleConns),
+	}
+}
+
+// CheckIdle runs ping against every currently idle connection, each with a
+// deadline of timeout, and evicts any that fail instead of leaving a stale
+// conn in the pool for a future Get to discover only via a wasted
+// req.Write/resp.Read round trip. Conns are pinged concurrently and each is
+// only pulled out of the idle list for the duration of its own ping, so a
+// sweep over N conns costs about one timeout, not N, and a concurrent Get
+// can still find every conn this sweep hasn't reached yet.
+func (p *connPool) CheckIdle(ping func(net.Conn) error, timeout time.Duration) {
+	p.mu.Lock()
+	snapshot := make([]*pooledConn, len(p.idle))
+	copy(snapshot, p.idle)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range snapshot {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !p.takeIdle(c) {
+				return // already claimed by a concurrent Get or the reaper
+			}
+			c.SetDeadline(time.Now().Add(timeout))
+			err := ping(c)
+			c.SetDeadline(time.Time{})
+			if err != nil {
+				p.removeConn(c)
+				return
+			}
+			p.mu.Lock()
+			p.idle = append(p.idle, c)
+			p.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// takeIdle removes target from the idle list if it is still there, reporting
+// whether it did so.
+func (p *connPool) takeIdle(target *pooledConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, c := range p.idle {
+		if c == target {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes every pooled connection, idle or not yet reaped, and stops
+// the background reaper. In-flight connections are closed as they are
+// returned via Put/Remove.
+func (p *connPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		p.removeConn(c)
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// selfSignedCert generates an in-memory self-signed certificate for host,
+// returning both the tls.Certificate (for the server) and the parsed leaf
+// (for a client's RootCAs pool).
+func selfSignedCert(t *testing.T, host string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{host},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, leaf
+}
+
+// TestClientTLSRoundTrip exercises createConn against a self-signed cert
+// end to end: the Client must trust the cert via TLSConfig.RootCAs and
+// come back with a real *tls.Conn.
+func TestClientTLSRoundTrip(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "127.0.0.1")
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PONG"))
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	host := NewClient(l.Addr().String())
+	host.TLSConfig = &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+
+	conn, err := host.createConn()
+	if err != nil {
+		t.Fatalf("createConn: %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("expected a *tls.Conn, got %T", conn)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "PONG" {
+		t.Fatalf("unexpected read result: %q, %v", buf[:n], err)
+	}
+}
+
+// TestClientTLSVerificationFailure is the negative case: a Client that
+// doesn't trust the server's self-signed cert must fail to connect.
+func TestClientTLSVerificationFailure(t *testing.T) {
+	cert, _ := selfSignedCert(t, "127.0.0.1")
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}()
+
+	host := NewClient(l.Addr().String())
+	host.TLSConfig = &tls.Config{ServerName: "127.0.0.1"} // no RootCAs: self-signed cert is untrusted
+
+	if _, err := host.createConn(); err == nil {
+		t.Fatal("expected certificate verification to fail, got nil error")
+	}
+}
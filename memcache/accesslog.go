@@ -0,0 +1,101 @@
+package memcache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// RequestInfo carries everything an AccessLogger might want to record about
+// one request/response round trip.
+type RequestInfo struct {
+	RemoteAddr string
+	Cmd        string
+	Keys       []string
+	ReqSize    int // bytes of request payload (e.g. the stored Item body)
+	RespSize   int // bytes of response payload (e.g. summed Get item bodies)
+	Duration   time.Duration
+	Err        error
+	Hits       int
+	Misses     int
+}
+
+// AccessLogger receives one RequestInfo per served request. Implementations
+// must not block Serve for long, since LogRequest runs inline on the
+// connection's goroutine.
+type AccessLogger interface {
+	LogRequest(ctx context.Context, info RequestInfo)
+}
+
+// TextAccessLogger formats RequestInfo the same way the old AccessLog
+// *log.Logger did: "remoteAddr cmd keys size duration_ms".
+type TextAccessLogger struct {
+	Logger *log.Logger
+}
+
+// NewTextAccessLogger wraps an existing *log.Logger for back-compat with
+// the previous plain-text access log format.
+func NewTextAccessLogger(logger *log.Logger) *TextAccessLogger {
+	return &TextAccessLogger{Logger: logger}
+}
+
+func (a *TextAccessLogger) LogRequest(ctx context.Context, info RequestInfo) {
+	key := ""
+	if len(info.Keys) > 0 {
+		key = info.Keys[0]
+		for _, k := range info.Keys[1:] {
+			key += ":" + k
+		}
+	}
+	size := info.ReqSize
+	if size == 0 {
+		size = info.RespSize
+	}
+	a.Logger.Printf("%s %s %s %d %dms", info.RemoteAddr, info.Cmd, key, size, info.Duration.Nanoseconds()/1e6)
+}
+
+// JSONAccessLogger writes each RequestInfo as one JSON object per line,
+// for operators who want to ship access logs to something that parses
+// structured fields instead of a fixed text format.
+type JSONAccessLogger struct {
+	Logger *log.Logger
+}
+
+func NewJSONAccessLogger(logger *log.Logger) *JSONAccessLogger {
+	return &JSONAccessLogger{Logger: logger}
+}
+
+type jsonRequestInfo struct {
+	RemoteAddr string   `json:"remote_addr"`
+	Cmd        string   `json:"cmd"`
+	Keys       []string `json:"keys,omitempty"`
+	ReqSize    int      `json:"req_size"`
+	RespSize   int      `json:"resp_size"`
+	DurationMs int64    `json:"duration_ms"`
+	Err        string   `json:"err,omitempty"`
+	Hits       int      `json:"hits,omitempty"`
+	Misses     int      `json:"misses,omitempty"`
+}
+
+func (a *JSONAccessLogger) LogRequest(ctx context.Context, info RequestInfo) {
+	j := jsonRequestInfo{
+		RemoteAddr: info.RemoteAddr,
+		Cmd:        info.Cmd,
+		Keys:       info.Keys,
+		ReqSize:    info.ReqSize,
+		RespSize:   info.RespSize,
+		DurationMs: info.Duration.Nanoseconds() / 1e6,
+		Hits:       info.Hits,
+		Misses:     info.Misses,
+	}
+	if info.Err != nil {
+		j.Err = info.Err.Error()
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		a.Logger.Printf("access log marshal failed: %v", err)
+		return
+	}
+	a.Logger.Print(string(b))
+}
@@ -2,31 +2,43 @@ package memcache
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
-var AccessLog *log.Logger = nil
 var SlowCmdTime = time.Millisecond * 100 // 100ms
 
 type ServerConn struct {
 	RemoteAddr      string
 	rwc             io.ReadWriteCloser // i/o connection
 	closeAfterReply bool
+	ctx             context.Context
+	cancel          context.CancelFunc
+
+	// AccessLog, if non-nil, receives one RequestInfo per served request.
+	// SlowLog, if non-nil, additionally receives only the requests whose
+	// processing time exceeded SlowCmdTime, so operators can route those
+	// to a separate sink without also capturing every plain get.
+	AccessLog AccessLogger
+	SlowLog   AccessLogger
 }
 
-func newServerConn(conn net.Conn) *ServerConn {
+func newServerConn(conn net.Conn, parent context.Context, accessLog, slowLog AccessLogger) *ServerConn {
 	c := new(ServerConn)
 	c.RemoteAddr = conn.RemoteAddr().String()
 	c.rwc = conn
+	c.ctx, c.cancel = context.WithCancel(parent)
+	c.AccessLog = accessLog
+	c.SlowLog = slowLog
 	return c
 }
 
@@ -37,8 +49,13 @@ func (c *ServerConn) Close() {
 	}
 }
 
+// Shutdown sets closeAfterReply and cancels c.ctx; the ctx-watcher in Serve
+// then closes the connection at once, so a pending read or reply write
+// fails instead of waiting. A Process call already running is not
+// interrupted: it finishes, and its reply is lost with the closed conn.
 func (c *ServerConn) Shutdown() {
 	c.closeAfterReply = true
+	c.cancel()
 }
 
 // 服务这个链接
@@ -46,6 +63,20 @@ func (c *ServerConn) Serve(store Storage, stats *Stats) (e error) {
 	rbuf := bufio.NewReader(c.rwc)
 	wbuf := bufio.NewWriter(c.rwc)
 
+	// watch for ctx cancellation (Shutdown or server-wide cancel) and close
+	// the connection so a blocked request read or reply write is interrupted
+	// instead of left to drain on its own. Process does not use the
+	// connection and is not interrupted.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
 	req := new(Request)
 	for {
 		e = req.Read(rbuf)
@@ -60,7 +91,8 @@ func (c *ServerConn) Serve(store Storage, stats *Stats) (e error) {
 		}
 		// 处理这个请求的用时
 		dt := time.Since(t)
-		if dt > SlowCmdTime {
+		slow := dt > SlowCmdTime
+		if slow {
 			stats.UpdateStat("slow_cmd", 1)
 		}
 
@@ -70,18 +102,30 @@ func (c *ServerConn) Serve(store Storage, stats *Stats) (e error) {
 			}
 		}
 		// 写日志
-		if AccessLog != nil {
-			key := strings.Join(req.Keys, ":")
-			size := 0
+		if c.AccessLog != nil || (slow && c.SlowLog != nil) {
+			info := RequestInfo{
+				RemoteAddr: c.RemoteAddr,
+				Cmd:        req.Cmd,
+				Keys:       req.Keys,
+				Duration:   dt,
+			}
 			switch req.Cmd {
 			case "get", "gets":
 				for _, v := range resp.items {
-					size += len(v.Body)
+					info.RespSize += len(v.Body)
 				}
+				info.Hits = len(resp.items)
+				info.Misses = len(req.Keys) - info.Hits
 			case "set", "add", "replace":
-				size = len(req.Item.Body)
+				info.ReqSize = len(req.Item.Body)
+			}
+
+			if c.AccessLog != nil {
+				c.AccessLog.LogRequest(c.ctx, info)
+			}
+			if slow && c.SlowLog != nil {
+				c.SlowLog.LogRequest(c.ctx, info)
 			}
-			AccessLog.Printf("%s %s %s %d %dms", c.RemoteAddr, req.Cmd, key, size, dt.Nanoseconds()/1e6)
 		}
 
 		req.Clear()
@@ -97,12 +141,35 @@ func (c *ServerConn) Serve(store Storage, stats *Stats) (e error) {
 
 type Server struct {
 	sync.Mutex
-	addr  string
-	l     net.Listener
-	store Storage
-	conns map[string]*ServerConn
-	stats *Stats
-	stop  bool
+	addr   string
+	l      net.Listener
+	store  Storage
+	conns  map[string]*ServerConn
+	stats  *Stats
+	stop   bool
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// MaxConns caps curr_connections; once reached, Serve closes newly
+	// accepted connections instead of adding them to conns, so the accept
+	// loop keeps draining and a client sees a reset rather than being
+	// queued forever. Zero means unlimited.
+	MaxConns int
+
+	// AccessLog and SlowLog are handed to every ServerConn created by
+	// Serve; see ServerConn for what each receives. Nil disables that sink.
+	AccessLog AccessLogger
+	SlowLog   AccessLogger
+
+	// TCP tuning applied to every accepted *net.TCPConn. A zero
+	// TCPKeepAlivePeriod or buffer size leaves that knob untouched;
+	// TCPKeepAlive and TCPNoDelay are always applied, so false turns them
+	// off (NewServer sets both to true).
+	TCPKeepAlive       bool
+	TCPKeepAlivePeriod time.Duration
+	TCPReadBufferSize  int
+	TCPWriteBufferSize int
+	TCPNoDelay         bool
 }
 
 func NewServer(store Storage) *Server {
@@ -111,15 +178,53 @@ func NewServer(store Storage) *Server {
 	// 最多1024个链接？
 	s.conns = make(map[string]*ServerConn, 1024)
 	s.stats = NewStats()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.TCPKeepAlive = true
+	s.TCPKeepAlivePeriod = 30 * time.Second
+	s.TCPNoDelay = true
 	return s
 }
 
+// tuneTCPConn applies the Server's TCP knobs to conn if it is a plain
+// *net.TCPConn; TLS-wrapped connections don't expose these and are left
+// alone.
+func (s *Server) tuneTCPConn(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	tc.SetKeepAlive(s.TCPKeepAlive)
+	if s.TCPKeepAlivePeriod > 0 {
+		tc.SetKeepAlivePeriod(s.TCPKeepAlivePeriod)
+	}
+	if s.TCPReadBufferSize > 0 {
+		tc.SetReadBuffer(s.TCPReadBufferSize)
+	}
+	if s.TCPWriteBufferSize > 0 {
+		tc.SetWriteBuffer(s.TCPWriteBufferSize)
+	}
+	tc.SetNoDelay(s.TCPNoDelay)
+}
+
 func (s *Server) Listen(addr string) (e error) {
 	s.addr = addr
 	s.l, e = net.Listen("tcp", addr)
 	return
 }
 
+// ListenTLS is like Listen but wraps the listener so every accepted
+// connection is a TLS connection. Set cfg.ClientAuth (e.g.
+// tls.RequireAndVerifyClientCert) and cfg.ClientCAs to require mutual auth.
+func (s *Server) ListenTLS(addr string, cfg *tls.Config) (e error) {
+	s.addr = addr
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+	s.l = tls.NewListener(l, cfg)
+	return nil
+}
+
 // server 用于管理链接
 func (s *Server) Serve() (e error) {
 	if s.l == nil {
@@ -146,12 +251,32 @@ func (s *Server) Serve() (e error) {
 		if s.stop {
 			break
 		}
-		c := newServerConn(rw)
+
+		s.Lock()
+		full := s.MaxConns > 0 && int64(s.stats.curr_connections) >= int64(s.MaxConns)
+		if !full {
+			// Reserve the slot here, synchronously, rather than in the
+			// spawned goroutine below: otherwise a burst of accepts can
+			// all observe the pre-increment count and pass the MaxConns
+			// check before any of them increments it, overshooting the
+			// limit.
+			s.stats.curr_connections++
+			s.stats.total_connections++
+		}
+		s.Unlock()
+		if full {
+			// Refuse rather than queue: close immediately so the accept
+			// loop keeps draining and the client sees a reset instead of
+			// an unbounded wait.
+			rw.Close()
+			continue
+		}
+
+		s.tuneTCPConn(rw)
+		c := newServerConn(rw, s.ctx, s.AccessLog, s.SlowLog)
 		go func() {
 			s.Lock()
 			s.conns[c.RemoteAddr] = c
-			s.stats.curr_connections++
-			s.stats.total_connections++
 			s.Unlock()
 
 			c.Serve(s.store, s.stats)
@@ -178,6 +303,7 @@ func (s *Server) Serve() (e error) {
 
 func (s *Server) Shutdown() {
 	s.stop = true
+	s.cancel()
 
 	// try to connect
 	net.Dial("tcp", s.addr)
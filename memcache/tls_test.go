@@ -0,0 +1,107 @@
+package memcache
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// selfSignedCert generates an in-memory self-signed certificate for host,
+// returning both the tls.Certificate (for the server) and the parsed leaf
+// (for a client's RootCAs pool).
+func selfSignedCert(t *testing.T, host string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{host},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, leaf
+}
+
+// TestServerListenTLSRoundTrip exercises ListenTLS end to end: a client that
+// trusts the self-signed cert must complete the handshake.
+func TestServerListenTLSRoundTrip(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "127.0.0.1")
+
+	s := NewServer(nil)
+	if err := s.ListenTLS("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Fatalf("ListenTLS: %v", err)
+	}
+	defer s.l.Close()
+
+	go func() {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if tc, ok := conn.(*tls.Conn); ok {
+			tc.Handshake()
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	conn, err := tls.Dial("tcp", s.l.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("expected TLS handshake to succeed: %v", err)
+	}
+	conn.Close()
+}
+
+// TestServerListenTLSVerificationFailure is the negative case: a client
+// that doesn't trust the server's self-signed cert must fail to connect.
+func TestServerListenTLSVerificationFailure(t *testing.T) {
+	cert, _ := selfSignedCert(t, "127.0.0.1")
+
+	s := NewServer(nil)
+	if err := s.ListenTLS("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Fatalf("ListenTLS: %v", err)
+	}
+	defer s.l.Close()
+
+	go func() {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if tc, ok := conn.(*tls.Conn); ok {
+			tc.Handshake()
+		}
+	}()
+
+	_, err := tls.Dial("tcp", s.l.Addr().String(), &tls.Config{ServerName: "127.0.0.1"})
+	if err == nil {
+		t.Fatal("expected certificate verification to fail, got nil error")
+	}
+}